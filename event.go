@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/coreos/nova-agent-watcher/Godeps/_workspace/src/github.com/coreos/coreos-cloudinit/initialize"
+	"github.com/coreos/nova-agent-watcher/Godeps/_workspace/src/github.com/coreos/go-systemd/dbus"
+	"github.com/coreos/nova-agent-watcher/providers"
+)
+
+// runEvent looks up the provider for full_path, renders its cloud-config,
+// and applies it. It short-circuits when the state cache shows the file is
+// unchanged since it was last applied, or, failing that, when the rendered
+// cloud-config is unchanged since it was last applied.
+func runEvent(full_path string, watch_dir string, cache *stateCache, force bool, dry_run bool) error {
+	if _, err := os.Stat(full_path); err != nil {
+		return err
+	}
+	file_name, err := filepath.Rel(watch_dir, full_path)
+	if err != nil {
+		log.Println("error getting relative path for:", full_path)
+		return err
+	}
+	func_key := filepath.Join("/", file_name)
+
+	provider := providers.Lookup(func_key)
+	if provider == nil {
+		log.Println("no handler found for", func_key)
+		return nil
+	}
+	contents, err := ioutil.ReadFile(full_path)
+	if err != nil {
+		log.Println("error reading file", err)
+		return err
+	}
+
+	file_hash := hashBytes(contents)
+	if old_hash, ok := cache.get(func_key); !force && ok && old_hash == file_hash {
+		log.Printf("state: path=%s old_hash=%s new_hash=%s action=skip-unchanged-file", func_key, old_hash, file_hash)
+		return nil
+	}
+
+	config, err := provider.Parse(func_key, contents)
+	if err != nil {
+		log.Println("error in handler", err)
+		return err
+	}
+
+	config_key := func_key + ":config"
+	config_hash := hashBytes([]byte(config.String()))
+	if old_hash, ok := cache.get(config_key); !force && ok && old_hash == config_hash {
+		log.Printf("state: path=%s old_hash=%s new_hash=%s action=skip-unchanged-config", func_key, old_hash, config_hash)
+		if dry_run {
+			return nil
+		}
+		return cache.set(func_key, file_hash)
+	}
+
+	if err := runConfig(config, dry_run); err != nil {
+		return err
+	}
+
+	if dry_run {
+		log.Printf("state: path=%s action=skip-dry-run", func_key)
+		return nil
+	}
+
+	old_hash, _ := cache.get(func_key)
+	log.Printf("state: path=%s old_hash=%s new_hash=%s action=apply", func_key, old_hash, file_hash)
+
+	if err := cache.set(func_key, file_hash); err != nil {
+		return err
+	}
+	return cache.set(config_key, config_hash)
+}
+
+// parsePath looks up the provider for path and renders its cloud-config,
+// for the "render" and "apply" subcommands. path is rebased against
+// watch_dir the same way runEvent rebases watched files, so a pulled-down
+// copy of e.g. /etc/conf.d/net can be passed as
+// <watch_dir>/etc/conf.d/net without it having to live at its canonical
+// location on the machine running the tool.
+func parsePath(path string, watch_dir string) (*initialize.CloudConfig, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file_name, err := filepath.Rel(watch_dir, path)
+	if err != nil {
+		return nil, err
+	}
+	func_key := filepath.Join("/", file_name)
+
+	provider := providers.Lookup(func_key)
+	if provider == nil {
+		return nil, fmt.Errorf("no provider registered for %s", func_key)
+	}
+	return provider.Parse(func_key, contents)
+}
+
+// unitMu serializes transient-unit applications. coreos-cloudinit isn't
+// safe to run concurrently against the same system (e.g. a shadow and an
+// authorized_keys change landing in the same second), so only one unit is
+// ever in flight at a time.
+var unitMu sync.Mutex
+
+// runConfig applies config by running coreos-cloudinit as a transient
+// systemd unit and waiting for it to finish, or, in dry_run mode, logs the
+// cloud-config that would have been applied.
+func runConfig(config *initialize.CloudConfig, dry_run bool) error {
+	if dry_run {
+		log.Printf("dry-run: would apply cloud-config:\n%s", config.String())
+		return nil
+	}
+
+	f, err := ioutil.TempFile("", "rackspace-cloudinit-")
+	if err != nil {
+		return err
+	}
+	log.Println("writing to:", f.Name())
+	_, err = f.WriteString(config.String())
+	if err != nil {
+		return err
+	}
+	// systemd-run coreos-cloudinit --file f.Name()
+	props := []dbus.Property{
+		dbus.PropDescription("Unit generated and executed by coreos-cloudinit on behalf of user"),
+		dbus.PropExecStart([]string{"/usr/bin/coreos-cloudinit", "--from-file", f.Name()}, false),
+	}
+
+	tmp_file := filepath.Base(f.Name())
+	name := fmt.Sprintf("%s.service", tmp_file)
+
+	conn, err := dbus.New()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unitMu.Lock()
+	defer unitMu.Unlock()
+
+	log.Printf("Creating transient systemd unit '%s'", name)
+	result := make(chan string, 1)
+	if _, err := conn.StartTransientUnit(name, "replace", props, result); err != nil {
+		return err
+	}
+
+	if status := <-result; status != "done" {
+		return unitFailure(conn, name, status)
+	}
+	return nil
+}
+
+// exitError pairs an error with the process exit code a caller should use
+// to report it, so a subcommand like `apply` can hand a caller (e.g. a CI
+// pipeline) a specific, scriptable status instead of a flat failure.
+type exitError struct {
+	err  error
+	code int
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+
+// unitResultCodes maps a systemd job result to a process exit code, so
+// callers of `apply` can distinguish failure modes without parsing log
+// output. "failed" is overridden below with the unit's actual
+// ExecMainStatus when one is available.
+var unitResultCodes = map[string]int{
+	"failed":     1,
+	"canceled":   2,
+	"timeout":    3,
+	"dependency": 4,
+}
+
+// unitFailure builds an error describing why unit didn't complete
+// successfully, pulling its systemd "Result" property for context (e.g.
+// "exit-code", "timeout") since the job result alone doesn't say why, and
+// an exit code a caller can propagate to its own process.
+func unitFailure(conn *dbus.Conn, unit string, status string) error {
+	result := "unknown"
+	prop, err := conn.GetUnitProperty(unit, "Result")
+	if err != nil {
+		log.Println("warn: error fetching unit Result property:", err)
+	} else if v, ok := prop.Value.Value().(string); ok {
+		result = v
+	}
+
+	code, ok := unitResultCodes[status]
+	if !ok {
+		code = 1
+	}
+	if status == "failed" {
+		if prop, err := conn.GetUnitProperty(unit, "ExecMainStatus"); err == nil {
+			if v, ok := prop.Value.Value().(int32); ok && v != 0 {
+				code = int(v)
+			}
+		}
+	}
+
+	return &exitError{
+		err:  fmt.Errorf("coreos-cloudinit unit %s %s, result=%s", unit, status, result),
+		code: code,
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFixture writes contents to watch_dir/rel, creating parent
+// directories as needed, and returns the full path.
+func writeFixture(t *testing.T, watch_dir, rel, contents string) string {
+	t.Helper()
+	full_path := filepath.Join(watch_dir, rel)
+	if err := os.MkdirAll(filepath.Dir(full_path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(full_path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return full_path
+}
+
+func TestParsePathRebasesAgainstWatchDir(t *testing.T) {
+	watch_dir, err := ioutil.TempDir("", "nova-agent-watcher-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(watch_dir)
+
+	full_path := writeFixture(t, watch_dir, "etc/conf.d/hostname", `HOSTNAME="polvi-test"`)
+
+	config, err := parsePath(full_path, watch_dir)
+	if err != nil {
+		t.Fatalf("parsePath: %v", err)
+	}
+	if config.Hostname != "polvi-test" {
+		t.Errorf("got hostname %q, want %q", config.Hostname, "polvi-test")
+	}
+}
+
+func TestRunEventDryRunDoesNotTouchCache(t *testing.T) {
+	watch_dir, err := ioutil.TempDir("", "nova-agent-watcher-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(watch_dir)
+
+	full_path := writeFixture(t, watch_dir, "etc/conf.d/hostname", `HOSTNAME="polvi-test"`)
+
+	state_dir, err := ioutil.TempDir("", "nova-agent-watcher-state-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(state_dir)
+
+	cache, err := loadStateCache(state_dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runEvent(full_path, watch_dir, cache, false, true); err != nil {
+		t.Fatalf("runEvent: %v", err)
+	}
+
+	if len(cache.Hashes) != 0 {
+		t.Errorf("dry-run runEvent recorded cache entries: %v", cache.Hashes)
+	}
+	if _, err := os.Stat(filepath.Join(state_dir, "state.json")); !os.IsNotExist(err) {
+		t.Errorf("dry-run runEvent unexpectedly wrote %s", filepath.Join(state_dir, "state.json"))
+	}
+}
+
+func TestCmdRenderAgainstFixtureCopy(t *testing.T) {
+	watch_dir, err := ioutil.TempDir("", "nova-agent-watcher-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(watch_dir)
+
+	full_path := writeFixture(t, watch_dir, "etc/conf.d/net", `config_eth0=("dhcp")`)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = cmdRender([]string{"-watch-dir", watch_dir, full_path})
+	w.Close()
+	os.Stdout = stdout
+	if err != nil {
+		t.Fatalf("cmdRender: %v", err)
+	}
+
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "DHCP=yes") {
+		t.Errorf("rendered cloud-config missing DHCP=yes:\n%s", out)
+	}
+}
@@ -0,0 +1,34 @@
+package providers
+
+import (
+	"io/ioutil"
+
+	"github.com/coreos/nova-agent-watcher/Godeps/_workspace/src/gopkg.in/yaml.v2"
+)
+
+// WriteFilesConfig is the YAML allow-list of files the WriteFilesProvider is
+// permitted to mirror into a cloud-config's write_files section.
+type WriteFilesConfig struct {
+	Files []WriteFileEntry `yaml:"files"`
+}
+
+// WriteFileEntry names a single allow-listed path and the ownership/mode to
+// apply when it's emitted as a write_files entry.
+type WriteFileEntry struct {
+	Path        string `yaml:"path"`
+	Owner       string `yaml:"owner"`
+	Permissions string `yaml:"permissions"`
+}
+
+// LoadWriteFilesConfig reads a WriteFilesConfig from a YAML file at path.
+func LoadWriteFilesConfig(path string) (*WriteFilesConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &WriteFilesConfig{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
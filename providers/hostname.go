@@ -0,0 +1,25 @@
+package providers
+
+import (
+	"regexp"
+
+	"github.com/coreos/nova-agent-watcher/Godeps/_workspace/src/github.com/coreos/coreos-cloudinit/initialize"
+)
+
+// HostnameProvider parses a Gentoo style /etc/conf.d/hostname file.
+type HostnameProvider struct{}
+
+func (HostnameProvider) Detect(path string) bool {
+	return path == "/etc/conf.d/hostname"
+}
+
+// HOSTNAME="polvi-test"
+var hostnameRe = regexp.MustCompile(`HOSTNAME="(.+)"`)
+
+func (HostnameProvider) Parse(path string, contents []byte) (*initialize.CloudConfig, error) {
+	config := &initialize.CloudConfig{}
+	if keys := hostnameRe.FindStringSubmatch(string(contents)); len(keys) == 2 {
+		config.Hostname = keys[1]
+	}
+	return config, nil
+}
@@ -0,0 +1,97 @@
+package providers
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+
+	"github.com/coreos/nova-agent-watcher/Godeps/_workspace/src/github.com/coreos/coreos-cloudinit/initialize"
+	"github.com/coreos/nova-agent-watcher/Godeps/_workspace/src/github.com/coreos/coreos-cloudinit/system"
+)
+
+// NetProvider parses a Gentoo-style /etc/conf.d/net file directly into
+// systemd-networkd ".network" unit content, replacing the old
+// gentoo-to-networkd shell pipeline.
+type NetProvider struct{}
+
+func (NetProvider) Detect(path string) bool {
+	return path == "/etc/conf.d/net"
+}
+
+var (
+	ethRe        = regexp.MustCompile(`eth\d+`)
+	configRe     = regexp.MustCompile(`config_(eth\d+)=\(\s*"([^"]*)"\s*\)`)
+	routesRe     = regexp.MustCompile(`routes_(eth\d+)=\(((?:\s*"[^"]*"\s*)+)\)`)
+	quotedRe     = regexp.MustCompile(`"([^"]*)"`)
+	defaultViaRe = regexp.MustCompile(`^default\s+via\s+(\S+)$`)
+)
+
+func (NetProvider) Parse(path string, contents []byte) (*initialize.CloudConfig, error) {
+	text := string(contents)
+	config := &initialize.CloudConfig{}
+
+	configured := map[string]bool{}
+	for _, eth := range ethRe.FindAllString(text, -1) {
+		if configured[eth] {
+			continue
+		}
+		configured[eth] = true
+
+		unit := system.Unit{
+			Name:    fmt.Sprintf("50-%s.network", eth),
+			Content: networkUnit(eth, text),
+		}
+		config.Coreos.Units = append(config.Coreos.Units, unit)
+	}
+	return config, nil
+}
+
+// networkUnit renders the .network unit content for eth from its
+// config_ethN and routes_ethN bash array entries, e.g.
+// config_eth0=("192.168.1.2/24") or config_eth0=("dhcp").
+func networkUnit(eth, text string) string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "[Match]\nName=%s\n\n[Network]\n", eth)
+
+	switch addr := arrayValue(configRe, eth, text); {
+	case addr == "dhcp":
+		fmt.Fprintf(&buf, "DHCP=yes\n")
+	case addr != "":
+		fmt.Fprintf(&buf, "Address=%s\n", addr)
+	}
+
+	if gw := defaultGateway(eth, text); gw != "" {
+		fmt.Fprintf(&buf, "Gateway=%s\n", gw)
+	}
+
+	return buf.String()
+}
+
+// arrayValue returns the quoted value of the bash array assignment matched
+// by re for the given interface, e.g. config_eth0=("dhcp") -> "dhcp".
+func arrayValue(re *regexp.Regexp, eth, text string) string {
+	for _, m := range re.FindAllStringSubmatch(text, -1) {
+		if m[1] == eth {
+			return m[2]
+		}
+	}
+	return ""
+}
+
+// defaultGateway scans eth's routes_ethN bash array, which may hold several
+// quoted routes (e.g. routes_eth0=("default via 192.168.1.1" "10.0.0.0/8
+// via 192.168.1.254")), and returns the gateway of its "default via <ip>"
+// entry, if any.
+func defaultGateway(eth, text string) string {
+	for _, m := range routesRe.FindAllStringSubmatch(text, -1) {
+		if m[1] != eth {
+			continue
+		}
+		for _, route := range quotedRe.FindAllStringSubmatch(m[2], -1) {
+			if gw := defaultViaRe.FindStringSubmatch(route[1]); len(gw) == 2 {
+				return gw[1]
+			}
+		}
+	}
+	return ""
+}
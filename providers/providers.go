@@ -0,0 +1,51 @@
+// Package providers implements metadata providers that translate the files
+// a Rackspace/Nova agent drops on disk into coreos-cloudinit CloudConfigs.
+package providers
+
+import (
+	"github.com/coreos/nova-agent-watcher/Godeps/_workspace/src/github.com/coreos/coreos-cloudinit/initialize"
+)
+
+// MetadataProvider knows how to recognize and parse a single metadata file
+// format dropped by a Nova or Rackspace agent.
+type MetadataProvider interface {
+	// Detect reports whether this provider handles the file at path, where
+	// path is rooted at "/" (e.g. "/etc/conf.d/net").
+	Detect(path string) bool
+
+	// Parse converts the raw file contents into a CloudConfig. path is the
+	// same "/"-rooted path passed to Detect, so providers that need to
+	// distinguish between several paths they Detect (e.g. WriteFilesProvider)
+	// don't have to stash state between the two calls.
+	Parse(path string, contents []byte) (*initialize.CloudConfig, error)
+}
+
+// Registered holds the default set of providers, consulted in order by
+// Lookup. Adding support for a new agent file format is a matter of
+// appending a provider here.
+var Registered = []MetadataProvider{
+	NetProvider{},
+	SSHProvider{},
+	ShadowProvider{},
+	HostnameProvider{},
+}
+
+// WatchPaths lists the canonical, "/"-rooted file paths understood by the
+// registered providers, for callers that need to set up filesystem watches.
+var WatchPaths = []string{
+	"/etc/conf.d/net",
+	"/root/.ssh/authorized_keys",
+	"/etc/shadow",
+	"/etc/conf.d/hostname",
+}
+
+// Lookup returns the first registered provider that detects path, or nil if
+// none do.
+func Lookup(path string) MetadataProvider {
+	for _, p := range Registered {
+		if p.Detect(path) {
+			return p
+		}
+	}
+	return nil
+}
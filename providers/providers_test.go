@@ -0,0 +1,144 @@
+package providers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNetProviderParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     []string // substrings expected in the rendered unit content
+		notWant  []string // substrings that must not appear
+	}{
+		{
+			name:     "dhcp",
+			contents: `config_eth0=("dhcp")`,
+			want:     []string{"[Match]", "Name=eth0", "DHCP=yes"},
+			notWant:  []string{"Address=", "Gateway="},
+		},
+		{
+			name:     "static address with single route",
+			contents: `config_eth0=("192.168.1.2/24")` + "\n" + `routes_eth0=("default via 192.168.1.1")`,
+			want:     []string{"Address=192.168.1.2/24", "Gateway=192.168.1.1"},
+		},
+		{
+			name: "static address with multiple routes",
+			contents: `config_eth0=("10.0.0.5/24")` + "\n" +
+				`routes_eth0=("default via 10.0.0.1" "192.168.0.0/16 via 10.0.0.254")`,
+			want: []string{"Address=10.0.0.5/24", "Gateway=10.0.0.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := NetProvider{}.Parse("/etc/conf.d/net", []byte(tt.contents))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if len(config.Coreos.Units) != 1 {
+				t.Fatalf("got %d units, want 1", len(config.Coreos.Units))
+			}
+			content := config.Coreos.Units[0].Content
+			for _, want := range tt.want {
+				if !strings.Contains(content, want) {
+					t.Errorf("unit content missing %q:\n%s", want, content)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(content, notWant) {
+					t.Errorf("unit content unexpectedly contains %q:\n%s", notWant, content)
+				}
+			}
+		})
+	}
+}
+
+func TestSSHProviderParse(t *testing.T) {
+	contents := "ssh-rsa AAAA...\nssh-ed25519 BBBB...\n"
+
+	config, err := SSHProvider{}.Parse("/root/.ssh/authorized_keys", []byte(contents))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(config.SSHAuthorizedKeys) != 2 {
+		t.Fatalf("got %d keys, want 2", len(config.SSHAuthorizedKeys))
+	}
+	if len(config.Users) != 1 || config.Users[0].Name != "root" {
+		t.Fatalf("want a single root user, got %+v", config.Users)
+	}
+	if len(config.Users[0].SSHAuthorizedKeys) != 2 {
+		t.Fatalf("got %d keys on root user, want 2", len(config.Users[0].SSHAuthorizedKeys))
+	}
+}
+
+func TestShadowProviderParse(t *testing.T) {
+	contents := "root:$1$NyBnu0Gl$GBoj9u6lx3R8nyqHuxPwz/:15839:0:::::\n"
+
+	config, err := ShadowProvider{}.Parse("/etc/shadow", []byte(contents))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(config.Users) != 2 {
+		t.Fatalf("got %d users, want 2", len(config.Users))
+	}
+	for _, name := range []string{"root", "core"} {
+		found := false
+		for _, u := range config.Users {
+			if u.Name == name && u.PasswordHash == "$1$NyBnu0Gl$GBoj9u6lx3R8nyqHuxPwz/" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("missing expected password hash for user %q", name)
+		}
+	}
+}
+
+func TestShadowProviderParseMalformed(t *testing.T) {
+	if _, err := (ShadowProvider{}).Parse("/etc/shadow", []byte("not a shadow file")); err == nil {
+		t.Fatal("expected an error for a malformed shadow file")
+	}
+}
+
+func TestHostnameProviderParse(t *testing.T) {
+	config, err := HostnameProvider{}.Parse("/etc/conf.d/hostname", []byte(`HOSTNAME="polvi-test"`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if config.Hostname != "polvi-test" {
+		t.Errorf("got hostname %q, want %q", config.Hostname, "polvi-test")
+	}
+}
+
+func TestWriteFilesProvider(t *testing.T) {
+	provider := NewWriteFilesProvider(&WriteFilesConfig{
+		Files: []WriteFileEntry{
+			{Path: "/var/lib/nova-agent/foo", Owner: "root:root", Permissions: "0644"},
+		},
+	})
+
+	if !provider.Detect("/var/lib/nova-agent/foo") {
+		t.Fatal("expected Detect to match an allow-listed path")
+	}
+	if provider.Detect("/var/lib/nova-agent/bar") {
+		t.Fatal("expected Detect to reject a path not on the allow-list")
+	}
+
+	config, err := provider.Parse("/var/lib/nova-agent/foo", []byte("hello"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if len(config.WriteFiles) != 1 {
+		t.Fatalf("got %d write_files entries, want 1", len(config.WriteFiles))
+	}
+	f := config.WriteFiles[0]
+	if f.Path != "/var/lib/nova-agent/foo" || f.Owner != "root:root" || f.RawFilePermissions != "0644" || f.Content != "hello" {
+		t.Errorf("unexpected write_files entry: %+v", f)
+	}
+
+	if _, err := provider.Parse("/var/lib/nova-agent/bar", []byte("hello")); err == nil {
+		t.Fatal("expected an error parsing a path not on the allow-list")
+	}
+}
@@ -0,0 +1,35 @@
+package providers
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/coreos/nova-agent-watcher/Godeps/_workspace/src/github.com/coreos/coreos-cloudinit/initialize"
+	"github.com/coreos/nova-agent-watcher/Godeps/_workspace/src/github.com/coreos/coreos-cloudinit/system"
+)
+
+// ShadowProvider parses a /etc/shadow style file for root's password hash.
+type ShadowProvider struct{}
+
+func (ShadowProvider) Detect(path string) bool {
+	return path == "/etc/shadow"
+}
+
+// root:$1$NyBnu0Gl$GBoj9u6lx3R8nyqHuxPwz/:15839:0:::::
+var shadowRe = regexp.MustCompile(`root:([^:]+):.+`)
+
+func (ShadowProvider) Parse(path string, contents []byte) (*initialize.CloudConfig, error) {
+	config := &initialize.CloudConfig{}
+
+	keys := shadowRe.FindStringSubmatch(string(contents))
+	if len(keys) != 2 {
+		return nil, errors.New("unable to parse password hash from shadow")
+	}
+	hash := keys[1]
+
+	config.Users = append(config.Users,
+		system.User{Name: "root", PasswordHash: hash},
+		system.User{Name: "core", PasswordHash: hash},
+	)
+	return config, nil
+}
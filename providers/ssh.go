@@ -0,0 +1,40 @@
+package providers
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/coreos/nova-agent-watcher/Godeps/_workspace/src/github.com/coreos/coreos-cloudinit/initialize"
+	"github.com/coreos/nova-agent-watcher/Godeps/_workspace/src/github.com/coreos/coreos-cloudinit/system"
+)
+
+// SSHProvider parses a /root/.ssh/authorized_keys file and authorizes the
+// keys found for both the root and core users.
+type SSHProvider struct{}
+
+func (SSHProvider) Detect(path string) bool {
+	return path == "/root/.ssh/authorized_keys"
+}
+
+var sshKeyRe = regexp.MustCompile(`ssh-\S.*`)
+
+func (SSHProvider) Parse(path string, contents []byte) (*initialize.CloudConfig, error) {
+	config := &initialize.CloudConfig{}
+	for _, line := range sshKeyRe.FindAllString(string(contents), -1) {
+		setKey(config, strings.TrimRight(line, "\r\n"))
+	}
+	return config, nil
+}
+
+// setKey adds key to the top-level ssh_authorized_keys (the core user) and
+// to the first configured user, creating a root user first if none exists.
+func setKey(config *initialize.CloudConfig, key string) {
+	config.SSHAuthorizedKeys = append(config.SSHAuthorizedKeys, key)
+	if len(config.Users) == 0 {
+		root := system.User{Name: "root"}
+		root.SSHAuthorizedKeys = append(root.SSHAuthorizedKeys, key)
+		config.Users = append(config.Users, root)
+		return
+	}
+	config.Users[0].SSHAuthorizedKeys = append(config.Users[0].SSHAuthorizedKeys, key)
+}
@@ -0,0 +1,56 @@
+package providers
+
+import (
+	"fmt"
+
+	"github.com/coreos/nova-agent-watcher/Godeps/_workspace/src/github.com/coreos/coreos-cloudinit/initialize"
+	"github.com/coreos/nova-agent-watcher/Godeps/_workspace/src/github.com/coreos/coreos-cloudinit/system"
+)
+
+// WriteFilesProvider mirrors arbitrary Nova-agent-dropped files verbatim
+// into a cloud-config's write_files section, per an operator-supplied
+// allow-list, typically paths under /var/lib/nova-agent/ or /mnt/config/.
+// Unlike the other providers it doesn't interpret the file contents.
+type WriteFilesProvider struct {
+	Entries []WriteFileEntry
+}
+
+// NewWriteFilesProvider builds a WriteFilesProvider from a loaded allow-list.
+// A nil config yields a provider that matches nothing.
+func NewWriteFilesProvider(config *WriteFilesConfig) *WriteFilesProvider {
+	if config == nil {
+		return &WriteFilesProvider{}
+	}
+	return &WriteFilesProvider{Entries: config.Files}
+}
+
+func (p *WriteFilesProvider) Detect(path string) bool {
+	_, ok := p.entry(path)
+	return ok
+}
+
+func (p *WriteFilesProvider) Parse(path string, contents []byte) (*initialize.CloudConfig, error) {
+	entry, ok := p.entry(path)
+	if !ok {
+		return nil, fmt.Errorf("no write_files entry allow-listed for %s", path)
+	}
+
+	config := &initialize.CloudConfig{}
+	config.WriteFiles = append(config.WriteFiles, system.File{
+		Path:               entry.Path,
+		Owner:              entry.Owner,
+		RawFilePermissions: entry.Permissions,
+		Content:            string(contents),
+	})
+	return config, nil
+}
+
+// entry returns the allow-list entry for path, if any.
+func (p *WriteFilesProvider) entry(path string) (WriteFileEntry, bool) {
+	for _, e := range p.Entries {
+		if e.Path == path {
+			return e, true
+		}
+	}
+	return WriteFileEntry{}, false
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// stateCache persists the last-applied SHA256 hash for each watched path,
+// and for the CloudConfig rendered from it, so unchanged files don't
+// trigger redundant coreos-cloudinit runs across events or reboots.
+type stateCache struct {
+	path string
+
+	mu     sync.Mutex
+	Hashes map[string]string `json:"hashes"`
+}
+
+// loadStateCache reads the state cache from dir/state.json, returning a
+// fresh, empty cache if it doesn't exist yet.
+func loadStateCache(dir string) (*stateCache, error) {
+	cache := &stateCache{
+		path:   filepath.Join(dir, "state.json"),
+		Hashes: map[string]string{},
+	}
+	data, err := ioutil.ReadFile(cache.path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// get returns the last recorded hash for key, and whether one was found.
+func (c *stateCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hash, ok := c.Hashes[key]
+	return hash, ok
+}
+
+// set records hash for key and persists the cache to disk.
+func (c *stateCache) set(key, hash string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Hashes[key] = hash
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.path, data, 0600)
+}
+
+// hashBytes returns the hex-encoded SHA256 digest of data.
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%x", sum)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/coreos/nova-agent-watcher/Godeps/_workspace/src/github.com/rjeczalik/notify"
+)
+
+// coalesceWindow bounds how long we wait for a path to go quiet before
+// acting on it, so a burst of events from a single atomic write (e.g. a
+// partial write followed by a rename-into-place) triggers one runEvent
+// instead of several.
+const coalesceWindow = 200 * time.Millisecond
+
+// watchRetryInterval is how long we wait before retrying the initial
+// notify.Watch setup if watch_dir doesn't exist yet (e.g. it's transiently
+// missing during early boot).
+const watchRetryInterval = 5 * time.Second
+
+// watch recursively watches watch_dir for file changes, coalescing bursts
+// of events per-path within coalesceWindow, and runs the matching provider
+// for each path once it settles. It never returns. watchEvents, the set of
+// notify.Event values subscribed to, is defined per-platform.
+func watch(watch_dir string, cache *stateCache, force bool, dry_run bool) {
+	events := make(chan notify.EventInfo, 64)
+	for {
+		err := notify.Watch(filepath.Join(watch_dir, "..."), events, watchEvents...)
+		if err == nil {
+			break
+		}
+		log.Println("warn: error setting up watch (dir doesn't exist?):", err)
+		time.Sleep(watchRetryInterval)
+	}
+	defer notify.Stop(events)
+
+	pending := map[string]*time.Timer{}
+	settled := make(chan string, 64)
+
+	for {
+		select {
+		case ev := <-events:
+			path := ev.Path()
+			log.Println("got event", ev.Event(), path)
+			if t, ok := pending[path]; ok {
+				t.Stop()
+			}
+			pending[path] = time.AfterFunc(coalesceWindow, func() {
+				settled <- path
+			})
+		case path := <-settled:
+			delete(pending, path)
+			if err := runEvent(path, watch_dir, cache, force, dry_run); err != nil {
+				log.Println("error handling event:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,16 @@
+// +build linux
+
+package main
+
+import "github.com/coreos/nova-agent-watcher/Godeps/_workspace/src/github.com/rjeczalik/notify"
+
+// watchEvents additionally subscribes to inotify's IN_CLOSE_WRITE, so a
+// file is handled again once its writer has closed it. Create and Write
+// events are still subscribed too, so a writer that pauses for longer than
+// coalesceWindow mid-write can still have its file handled before close.
+var watchEvents = []notify.Event{
+	notify.Create,
+	notify.Rename,
+	notify.Write,
+	notify.InCloseWrite,
+}
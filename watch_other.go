@@ -0,0 +1,12 @@
+// +build !linux
+
+package main
+
+import "github.com/coreos/nova-agent-watcher/Godeps/_workspace/src/github.com/rjeczalik/notify"
+
+// watchEvents omits inotify-specific events on non-Linux platforms.
+var watchEvents = []notify.Event{
+	notify.Create,
+	notify.Rename,
+	notify.Write,
+}